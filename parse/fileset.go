@@ -0,0 +1,123 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Position describes a human-readable source location: a Pos resolved
+// against the File it came from.
+type Position struct {
+	Filename string
+	Offset   int // byte offset, 0-based
+	Line     int // 1-based line number
+	Column   int // 1-based column number, counted in bytes
+}
+
+func (p Position) String() string {
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// File tracks the byte offsets of line starts for a single piece of
+// source text, so a Pos can be resolved to a Position in O(log n) via
+// binary search instead of rescanning the text on every lookup.
+type File struct {
+	name  string
+	size  int
+	lines []int // byte offset of the start of each line; lines[0] == 0
+}
+
+// NewFile creates a File of the given size. AddLine is expected to be
+// called, in increasing offset order, once for every line break the
+// lexer consumes while scanning that text.
+func NewFile(name string, size int) *File {
+	return &File{name: name, size: size, lines: []int{0}}
+}
+
+// AddLine records that a new line begins at offset. Calls with an offset
+// that doesn't advance past the last recorded line start are ignored, so
+// it's safe to call redundantly (e.g. when a lexer peeks the same
+// newline more than once).
+func (f *File) AddLine(offset int) {
+	if n := len(f.lines); n == 0 || f.lines[n-1] < offset {
+		f.lines = append(f.lines, offset)
+	}
+}
+
+// Position resolves a byte offset into this file to a Position.
+func (f *File) Position(offset int) Position {
+	i := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	if i < 0 {
+		i = 0
+	}
+	return Position{
+		Filename: f.name,
+		Offset:   offset,
+		Line:     i + 1,
+		Column:   offset - f.lines[i] + 1,
+	}
+}
+
+// FileSet is a collection of Files, keyed by name, for tools (editor
+// integrations, linters) that need to resolve positions across more than
+// one parsed source.
+type FileSet struct {
+	files map[string]*File
+}
+
+// NewFileSet creates an empty FileSet.
+func NewFileSet() *FileSet {
+	return &FileSet{files: make(map[string]*File)}
+}
+
+// AddFile creates, registers, and returns a new File of the given size.
+func (s *FileSet) AddFile(name string, size int) *File {
+	f := NewFile(name, size)
+	s.files[name] = f
+	return f
+}
+
+// File looks up a previously added File by name.
+func (s *FileSet) File(name string) *File {
+	return s.files[name]
+}
+
+// Error is a single structured parse error: a resolved source Position
+// plus a message.
+type Error struct {
+	Position Position
+	Msg      string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Position, e.Msg)
+}
+
+// ErrorList collects every Error found during one Parse call, enabling
+// multi-error recovery instead of aborting at the first bad token.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	var b strings.Builder
+	for i, e := range l {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(e.Error())
+	}
+	return b.String()
+}
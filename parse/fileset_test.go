@@ -0,0 +1,63 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package parse
+
+import "testing"
+
+func TestFilePosition(t *testing.T) {
+	text := "ab\ncd\n\nef"
+	f := NewFile("f.sml", len(text))
+	for i, c := range text {
+		if c == '\n' {
+			f.AddLine(i + 1)
+		}
+	}
+	tests := []struct {
+		offset   int
+		wantLine int
+		wantCol  int
+	}{
+		{0, 1, 1},  // 'a'
+		{2, 1, 3},  // '\n'
+		{3, 2, 1},  // 'c'
+		{6, 3, 1},  // empty line
+		{7, 4, 1},  // 'e'
+		{8, 4, 2},  // 'f'
+	}
+	for _, tt := range tests {
+		pos := f.Position(tt.offset)
+		if pos.Line != tt.wantLine || pos.Column != tt.wantCol {
+			t.Errorf("Position(%d) = %d:%d, want %d:%d", tt.offset, pos.Line, pos.Column, tt.wantLine, tt.wantCol)
+		}
+		if pos.Filename != "f.sml" {
+			t.Errorf("Position(%d).Filename = %q, want %q", tt.offset, pos.Filename, "f.sml")
+		}
+	}
+}
+
+func TestFileSet(t *testing.T) {
+	s := NewFileSet()
+	a := s.AddFile("a.sml", 4)
+	b := s.AddFile("b.sml", 4)
+	a.AddLine(2)
+	b.AddLine(3)
+
+	if got := s.File("a.sml"); got != a {
+		t.Errorf("File(%q) = %p, want %p", "a.sml", got, a)
+	}
+	if got := s.File("b.sml"); got != b {
+		t.Errorf("File(%q) = %p, want %p", "b.sml", got, b)
+	}
+	if got := s.File("missing.sml"); got != nil {
+		t.Errorf("File(%q) = %v, want nil", "missing.sml", got)
+	}
+
+	if got, want := a.Position(3).Line, 2; got != want {
+		t.Errorf("a.Position(3).Line = %d, want %d", got, want)
+	}
+	if got, want := b.Position(3).Line, 2; got != want {
+		t.Errorf("b.Position(3).Line = %d, want %d", got, want)
+	}
+}
@@ -50,14 +50,21 @@ const (
 	itemIntersection // && operator
 	itemUnion        // || operator
 	itemLocation     // @ operator
+	itemComma        // ',' argument separator
+	itemAssign       // '=' binding operator
+	itemSemicolon    // ';' statement separator
 	// Keywords appear after all the rest.
 	itemKeyword // used only to delimit the keywords
 	itemRect    // rect keyword
+	itemCircle  // circle keyword
+	itemPoly    // poly keyword
 
 )
 
 var key = map[string]itemType{
-	"rect": itemRect,
+	"rect":   itemRect,
+	"circle": itemCircle,
+	"poly":   itemPoly,
 }
 
 const eof = -1
@@ -69,6 +76,7 @@ type stateFn func(*lexer) stateFn
 type lexer struct {
 	name       string    // the name of the input; used only for error reports
 	input      string    // the string being scanned
+	file       *File     // records line starts as they're consumed, for Position lookups; may be nil
 	state      stateFn   // the next lexing function to enter
 	pos        Pos       // current position in the input
 	start      Pos       // start position of this item
@@ -87,6 +95,9 @@ func (l *lexer) next() rune {
 	r, w := utf8.DecodeRuneInString(l.input[l.pos:])
 	l.width = Pos(w)
 	l.pos += l.width
+	if r == '\n' && l.file != nil {
+		l.file.AddLine(int(l.pos))
+	}
 	return r
 }
 
@@ -129,18 +140,28 @@ func (l *lexer) acceptRun(valid string) {
 	l.backup()
 }
 
-// lineNumber reports which line we're on, based on the position of
-// the previous item returned by nextItem. Doing it this way
-// means we don't have to worry about peek double counting.
-func (l *lexer) lineNumber() int {
-	return 1 + strings.Count(l.input[:l.lastPos], "\n")
+// position resolves the position of the most recent item returned by
+// nextItem to a Position via l.file, which tracks line starts in
+// O(log n) rather than rescanning the input prefix on every call.
+func (l *lexer) position() Position {
+	if l.file == nil {
+		return Position{Offset: int(l.lastPos), Line: 1, Column: int(l.lastPos) + 1}
+	}
+	return l.file.Position(int(l.lastPos))
 }
 
-// errorf returns an error token and terminates the scan by passing
-// back a nil pointer that will be the next state, terminating l.nextItem.
+// errorf emits an error token and resumes scanning from lexBase, so one
+// bad rune doesn't stop the rest of the input from being tokenized. Every
+// call site has already advanced l.pos past the offending text, so this
+// makes forward progress; if that text ran all the way to the actual end
+// of input, lexBase's own eof case will emit itemEOF next and the scan
+// ends there, same as on well-formed input. This is what lets Tree.sync
+// resynchronize past a lex error instead of mistaking a silent, halted
+// lexer for having reached EOF.
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
 	l.items <- item{itemError, l.start, fmt.Sprintf(format, args...)}
-	return nil
+	l.ignore()
+	return lexBase
 }
 
 // nextItem returns the next item from the input.
@@ -150,11 +171,14 @@ func (l *lexer) nextItem() item {
 	return item
 }
 
-// lex creates a new scanner for the input string.
-func lex(name, input string) *lexer {
+// lex creates a new scanner for the input string. file, if non-nil, is
+// fed line starts as they're scanned so positions in this input can later
+// be resolved to line/column via file.Position.
+func lex(name, input string, file *File) *lexer {
 	l := &lexer{
 		name:  name,
 		input: input,
+		file:  file,
 		items: make(chan item),
 	}
 	go l.run()
@@ -197,6 +221,12 @@ func lexBase(l *lexer) stateFn {
 	switch r := l.next(); {
 	case isSpace(r):
 		return lexSpace
+	case r == '-' && !isDigit(l.peek()):
+		// A '-' not immediately followed by a digit is the binary diff
+		// operator, not the sign of a number (which scanNumber expects
+		// to directly precede its digits).
+		l.emit(itemDiff)
+		return lexBase
 	case r == '+' || r == '-' || ('0' <= r && r <= '9'):
 		l.backup()
 		return lexNumber
@@ -217,13 +247,23 @@ func lexBase(l *lexer) stateFn {
 	case r == '@':
 		l.emit(itemLocation)
 		return lexBase
+	case r == ',':
+		l.emit(itemComma)
+		return lexBase
+	case r == '=':
+		l.emit(itemAssign)
+		return lexBase
+	case r == ';':
+		l.emit(itemSemicolon)
+		return lexBase
+	case r == '"':
+		return lexQuote
 	case r == eof:
 		l.emit(itemEOF)
 		return nil
 	default:
 		return l.errorf("unrecognized character in action: %#U", r)
 	}
-	return lexBase
 }
 
 func lexLineComment(l *lexer) stateFn {
@@ -297,7 +337,7 @@ func (l *lexer) atTerminator() bool {
 		return true
 	}
 	switch r {
-	case eof, '.', ',', '|', ':', ')', '(':
+	case eof, '.', ',', '|', ':', ')', '(', ';', '=':
 		return true
 	}
 	return false
@@ -383,3 +423,8 @@ func isEndOfLine(r rune) bool {
 func isAlphaNumeric(r rune) bool {
 	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
 }
+
+// isDigit reports whether r is a decimal digit.
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
@@ -9,8 +9,11 @@ package parse
 import (
 	"bytes"
 	"fmt"
+	"image"
+	"math"
+	"sort"
 	"strconv"
-	//"strings"
+	"strings"
 )
 
 var textFormat = "%s" // Changed to "%q" in tests for better error messages.
@@ -57,6 +60,10 @@ const (
 	NodeIntersection                 // An intersection operator
 	NodeUnion                        // A union operator
 	NodeObject                       // A object declaration
+	NodeRectSet                      // A canonical set of disjoint rectangles
+	NodeIdentifier                   // A reference to a named binding
+	NodeAssign                       // A named-binding assignment
+	NodeString                       // A string literal
 )
 
 // Nodes.
@@ -73,6 +80,11 @@ func (t *Tree) newList(pos Pos) *ListNode {
 	return &ListNode{tr: t, NodeType: NodeList, Pos: pos}
 }
 
+// Reduce reduces every element of the list. A list holding exactly one
+// element (the shape every Tree.Root is built as) reduces to that
+// element directly, rather than a *ListNode wrapping it, so callers like
+// IdentifierNode.Reduce can treat tree.Root.Reduce() as yielding the
+// binding's value.
 func (t *ListNode) Reduce() (ret Node, err error) {
 	var list *ListNode = t.CopyList()
 	for i, v := range list.Nodes {
@@ -81,6 +93,9 @@ func (t *ListNode) Reduce() (ret Node, err error) {
 			return
 		}
 	}
+	if len(list.Nodes) == 1 {
+		return list.Nodes[0], nil
+	}
 	ret = list
 	return
 }
@@ -234,6 +249,180 @@ func (n *NumberNode) Copy() Node {
 	return nn
 }
 
+// StringNode holds a string literal, quotes and all.
+type StringNode struct {
+	NodeType
+	Pos
+	tr     *Tree
+	Quoted string // The original text of the string, with quotes.
+	Text   string // The string, after quote processing.
+}
+
+func (t *Tree) newString(pos Pos, orig, text string) *StringNode {
+	return &StringNode{tr: t, NodeType: NodeString, Pos: pos, Quoted: orig, Text: text}
+}
+
+func (t *StringNode) Reduce() (Node, error) {
+	return t.Copy(), nil
+}
+
+func (s *StringNode) String() string {
+	return s.Quoted
+}
+
+func (s *StringNode) tree() *Tree {
+	return s.tr
+}
+
+func (s *StringNode) Copy() Node {
+	return &StringNode{tr: s.tr, NodeType: NodeString, Pos: s.Pos, Quoted: s.Quoted, Text: s.Text}
+}
+
+// RectSetNode holds a canonical set of axis-aligned rectangles: sorted by
+// (Min.Y, Min.X), pairwise disjoint, and free of zero-area entries. It is
+// the value that geometric expressions (rect/diff/union/intersection)
+// reduce to.
+type RectSetNode struct {
+	NodeType
+	Pos
+	tr    *Tree
+	Rects []image.Rectangle
+}
+
+func (t *Tree) newRectSet(pos Pos, rects []image.Rectangle) *RectSetNode {
+	return &RectSetNode{tr: t, NodeType: NodeRectSet, Pos: pos, Rects: CanonicalRects(rects)}
+}
+
+func (n *RectSetNode) Reduce() (Node, error) {
+	return n.Copy(), nil
+}
+
+func (n *RectSetNode) String() string {
+	b := new(bytes.Buffer)
+	fmt.Fprint(b, "rectset[")
+	for i, r := range n.Rects {
+		if i > 0 {
+			fmt.Fprint(b, " ")
+		}
+		fmt.Fprintf(b, "(%d,%d)-(%d,%d)", r.Min.X, r.Min.Y, r.Max.X, r.Max.Y)
+	}
+	fmt.Fprint(b, "]")
+	return b.String()
+}
+
+func (n *RectSetNode) tree() *Tree {
+	return n.tr
+}
+
+func (n *RectSetNode) Copy() Node {
+	rects := make([]image.Rectangle, len(n.Rects))
+	copy(rects, n.Rects)
+	return &RectSetNode{tr: n.tr, NodeType: NodeRectSet, Pos: n.Pos, Rects: rects}
+}
+
+// CanonicalRects normalizes, drops empty rectangles, and sorts the result
+// by (Min.Y, Min.X) so that rectangle sets are directly comparable.
+func CanonicalRects(rects []image.Rectangle) []image.Rectangle {
+	out := make([]image.Rectangle, 0, len(rects))
+	for _, r := range rects {
+		r = r.Canon()
+		if r.Empty() {
+			continue
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Min.Y != out[j].Min.Y {
+			return out[i].Min.Y < out[j].Min.Y
+		}
+		return out[i].Min.X < out[j].Min.X
+	})
+	return out
+}
+
+// SubtractRect cuts cut out of r, returning the up to four surrounding
+// pieces (top, bottom, left, right strips) that remain. If cut does not
+// overlap r, r is returned unchanged.
+func SubtractRect(r, cut image.Rectangle) []image.Rectangle {
+	inter := r.Intersect(cut)
+	if inter.Empty() {
+		return []image.Rectangle{r}
+	}
+	var out []image.Rectangle
+	if inter.Min.Y > r.Min.Y {
+		out = append(out, image.Rect(r.Min.X, r.Min.Y, r.Max.X, inter.Min.Y))
+	}
+	if inter.Max.Y < r.Max.Y {
+		out = append(out, image.Rect(r.Min.X, inter.Max.Y, r.Max.X, r.Max.Y))
+	}
+	if inter.Min.X > r.Min.X {
+		out = append(out, image.Rect(r.Min.X, inter.Min.Y, inter.Min.X, inter.Max.Y))
+	}
+	if inter.Max.X < r.Max.X {
+		out = append(out, image.Rect(inter.Max.X, inter.Min.Y, r.Max.X, inter.Max.Y))
+	}
+	return out
+}
+
+// SubtractRectList subtracts every rectangle in cuts from every rectangle
+// in rects, iterating until nothing overlaps.
+func SubtractRectList(rects, cuts []image.Rectangle) []image.Rectangle {
+	pieces := rects
+	for _, cut := range cuts {
+		next := make([]image.Rectangle, 0, len(pieces))
+		for _, p := range pieces {
+			next = append(next, SubtractRect(p, cut)...)
+		}
+		pieces = next
+	}
+	return pieces
+}
+
+// DiffRectSets implements the `-` operator: for each rectangle in a,
+// subtract every rectangle in b.
+func DiffRectSets(a, b []image.Rectangle) []image.Rectangle {
+	var out []image.Rectangle
+	for _, r := range a {
+		out = append(out, SubtractRectList([]image.Rectangle{r}, b)...)
+	}
+	return CanonicalRects(out)
+}
+
+// UnionRectSets implements the `||` operator: a is taken as is, and each
+// rectangle of b is first cut down by the pieces already accepted into the
+// result so the output stays disjoint.
+func UnionRectSets(a, b []image.Rectangle) []image.Rectangle {
+	result := append([]image.Rectangle(nil), a...)
+	for _, r := range b {
+		result = append(result, SubtractRectList([]image.Rectangle{r}, result)...)
+	}
+	return CanonicalRects(result)
+}
+
+// IntersectRectSets implements the `&&` operator via pairwise
+// image.Rectangle.Intersect, dropping empties.
+func IntersectRectSets(a, b []image.Rectangle) []image.Rectangle {
+	var out []image.Rectangle
+	for _, ra := range a {
+		for _, rb := range b {
+			if in := ra.Intersect(rb); !in.Empty() {
+				out = append(out, in)
+			}
+		}
+	}
+	return CanonicalRects(out)
+}
+
+// asRectSet requires that n reduced to a RectSetNode, as is required on
+// both sides of the -, &&, and || operators.
+func asRectSet(n Node, op string) (*RectSetNode, error) {
+	rs, ok := n.(*RectSetNode)
+	if !ok {
+		return nil, fmt.Errorf("operand of %q is not a rectangle set: %v", op, n)
+	}
+	return rs, nil
+}
+
 // DiffNode holds a diff operation.
 type DiffNode struct {
 	NodeType
@@ -247,19 +436,23 @@ func (t *Tree) newDiff(pos Pos, lefthand Node, righthand Node) *DiffNode {
 	return &DiffNode{tr: t, NodeType: NodeDiff, Pos: pos, Lefthand: lefthand, Righthand: righthand}
 }
 func (t *DiffNode) Reduce() (ret Node, err error) {
-	var list *ListNode
-	list = t.tr.newList(t.Pos)
-	tmp, err := t.Lefthand.Reduce()
+	lhs, err := t.Lefthand.Reduce()
 	if err != nil {
 		return
 	}
-	list.append(tmp)
-	tmp, err = t.Righthand.Reduce()
+	rhs, err := t.Righthand.Reduce()
 	if err != nil {
 		return
 	}
-	list.append(tmp)
-	ret = list
+	lset, err := asRectSet(lhs, "-")
+	if err != nil {
+		return
+	}
+	rset, err := asRectSet(rhs, "-")
+	if err != nil {
+		return
+	}
+	ret = t.tr.newRectSet(t.Pos, DiffRectSets(lset.Rects, rset.Rects))
 	return
 }
 func (t *DiffNode) String() string {
@@ -288,19 +481,23 @@ func (t *Tree) newIntersection(pos Pos, lefthand Node, righthand Node) *Intersec
 }
 
 func (t *IntersectionNode) Reduce() (ret Node, err error) {
-	var list *ListNode
-	list = t.tr.newList(t.Pos)
-	tmp, err := t.Lefthand.Reduce()
+	lhs, err := t.Lefthand.Reduce()
 	if err != nil {
 		return
 	}
-	list.append(tmp)
-	tmp, err = t.Righthand.Reduce()
+	rhs, err := t.Righthand.Reduce()
 	if err != nil {
 		return
 	}
-	list.append(tmp)
-	ret = list
+	lset, err := asRectSet(lhs, "&&")
+	if err != nil {
+		return
+	}
+	rset, err := asRectSet(rhs, "&&")
+	if err != nil {
+		return
+	}
+	ret = t.tr.newRectSet(t.Pos, IntersectRectSets(lset.Rects, rset.Rects))
 	return
 }
 
@@ -330,19 +527,23 @@ func (t *Tree) newUnion(pos Pos, lefthand Node, righthand Node) *UnionNode {
 }
 
 func (t *UnionNode) Reduce() (ret Node, err error) {
-	var list *ListNode
-	list = t.tr.newList(t.Pos)
-	tmp, err := t.Lefthand.Reduce()
+	lhs, err := t.Lefthand.Reduce()
 	if err != nil {
 		return
 	}
-	list.append(tmp)
-	tmp, err = t.Righthand.Reduce()
+	rhs, err := t.Righthand.Reduce()
 	if err != nil {
 		return
 	}
-	list.append(tmp)
-	ret = list
+	lset, err := asRectSet(lhs, "||")
+	if err != nil {
+		return
+	}
+	rset, err := asRectSet(rhs, "||")
+	if err != nil {
+		return
+	}
+	ret = t.tr.newRectSet(t.Pos, UnionRectSets(lset.Rects, rset.Rects))
 	return
 }
 
@@ -371,10 +572,192 @@ type ObjectNode struct {
 func (t *Tree) newObject(pos Pos, ident string, params []Node, location_params []Node) *ObjectNode {
 	return &ObjectNode{tr: t, NodeType: NodeObject, Pos: pos, Ident: ident, Params: params, LocationParams: location_params}
 }
-func (t *ObjectNode) Reduce() (list Node, err error) {
-	list = t.Copy()
-	return
+func (t *ObjectNode) Reduce() (ret Node, err error) {
+	switch t.Ident {
+	case "rect":
+		return t.reduceRect()
+	case "circle":
+		return t.reduceCircle()
+	case "poly":
+		return t.reducePoly()
+	}
+	return nil, fmt.Errorf("unknown object %q", t.Ident)
 }
+
+// reduceRect evaluates a `rect(x0,y0,x1,y1)` object into a singleton
+// RectSetNode, normalized so x0<=x1 and y0<=y1 and translated by the `@`
+// location operands, if any.
+func (t *ObjectNode) reduceRect() (Node, error) {
+	if len(t.Params) != 4 {
+		return nil, fmt.Errorf("rect: want 4 params (x0,y0,x1,y1), got %d", len(t.Params))
+	}
+	coords := make([]int64, len(t.Params))
+	for i, p := range t.Params {
+		v, err := numberParamInt64(p)
+		if err != nil {
+			return nil, fmt.Errorf("rect: param %d: %v", i, err)
+		}
+		coords[i] = v
+	}
+	r := image.Rect(int(coords[0]), int(coords[1]), int(coords[2]), int(coords[3])).Canon()
+	dx, dy, err := t.locationOffset()
+	if err != nil {
+		return nil, err
+	}
+	r = r.Add(image.Pt(int(dx), int(dy)))
+	return t.tr.newRectSet(t.Pos, []image.Rectangle{r}), nil
+}
+
+// locationOffset evaluates the `@ (dx,dy)` translation attached to an
+// object, returning (0, 0, nil) if there is none.
+func (t *ObjectNode) locationOffset() (dx, dy int64, err error) {
+	if len(t.LocationParams) == 0 {
+		return 0, 0, nil
+	}
+	if len(t.LocationParams) != 2 {
+		return 0, 0, fmt.Errorf("@ location: want 2 params (dx,dy), got %d", len(t.LocationParams))
+	}
+	dx, err = numberParamInt64(t.LocationParams[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("@ location: param 0: %v", err)
+	}
+	dy, err = numberParamInt64(t.LocationParams[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("@ location: param 1: %v", err)
+	}
+	return dx, dy, nil
+}
+
+// numberParamInt64 coerces a param Node to an int64 via NumberNode's
+// Int64/Float64 fields, erroring if the value isn't representable.
+func numberParamInt64(n Node) (int64, error) {
+	num, ok := n.(*NumberNode)
+	if !ok {
+		return 0, fmt.Errorf("not a number: %v", n)
+	}
+	if num.IsInt {
+		return num.Int64, nil
+	}
+	if num.IsFloat && float64(int64(num.Float64)) == num.Float64 {
+		return int64(num.Float64), nil
+	}
+	return 0, fmt.Errorf("value %q is not representable as an integer", num.Text)
+}
+
+// reduceCircle evaluates a `circle(cx, cy, r)` object into a RectSetNode
+// by Bresenham-style scanline decomposition: one 1-pixel-tall horizontal
+// strip per row the circle covers, translated by the `@` location
+// operands, if any.
+func (t *ObjectNode) reduceCircle() (Node, error) {
+	if len(t.Params) != 3 {
+		return nil, fmt.Errorf("circle: want 3 params (cx,cy,r), got %d", len(t.Params))
+	}
+	cx, err := numberParamInt64(t.Params[0])
+	if err != nil {
+		return nil, fmt.Errorf("circle: param 0 (cx): %v", err)
+	}
+	cy, err := numberParamInt64(t.Params[1])
+	if err != nil {
+		return nil, fmt.Errorf("circle: param 1 (cy): %v", err)
+	}
+	r, err := numberParamInt64(t.Params[2])
+	if err != nil {
+		return nil, fmt.Errorf("circle: param 2 (r): %v", err)
+	}
+	if r <= 0 {
+		return nil, fmt.Errorf("circle: radius must be positive, got %d", r)
+	}
+	var rects []image.Rectangle
+	rr := float64(r) * float64(r)
+	for y := -r; y <= r; y++ {
+		dx := int64(math.Sqrt(rr - float64(y)*float64(y)))
+		rects = append(rects, image.Rect(int(cx-dx), int(cy+y), int(cx+dx+1), int(cy+y+1)))
+	}
+	dx, dy, err := t.locationOffset()
+	if err != nil {
+		return nil, err
+	}
+	pt := image.Pt(int(dx), int(dy))
+	for i, rect := range rects {
+		rects[i] = rect.Add(pt)
+	}
+	return t.tr.newRectSet(t.Pos, rects), nil
+}
+
+// reducePoly evaluates a `poly("x0 y0 x1 y1 ...")` object into a
+// RectSetNode via even-odd scanline polygon fill: for each integer row,
+// intersect the polygon's edges with the horizontal line through the
+// middle of that row, pair up the crossings, and emit one rectangle per
+// pair. Translated by the `@` location operands, if any.
+func (t *ObjectNode) reducePoly() (Node, error) {
+	if len(t.Params) != 1 {
+		return nil, fmt.Errorf("poly: want 1 param (coordinate string), got %d", len(t.Params))
+	}
+	str, ok := t.Params[0].(*StringNode)
+	if !ok {
+		return nil, fmt.Errorf("poly: param 0 must be a string, got %v", t.Params[0])
+	}
+	fields := strings.Fields(str.Text)
+	if len(fields) < 6 || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("poly: coordinate string must hold at least 3 x/y pairs, got %d numbers", len(fields))
+	}
+	type point struct{ x, y float64 }
+	verts := make([]point, len(fields)/2)
+	for i := range verts {
+		x, err := strconv.ParseFloat(fields[2*i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("poly: coordinate %d: %v", 2*i, err)
+		}
+		y, err := strconv.ParseFloat(fields[2*i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("poly: coordinate %d: %v", 2*i+1, err)
+		}
+		verts[i] = point{x, y}
+	}
+	ymin, ymax := verts[0].y, verts[0].y
+	for _, v := range verts {
+		ymin = math.Min(ymin, v.y)
+		ymax = math.Max(ymax, v.y)
+	}
+	var rects []image.Rectangle
+	for y := int(math.Floor(ymin)); y < int(math.Ceil(ymax)); y++ {
+		scanline := float64(y) + 0.5
+		var xs []float64
+		for i := range verts {
+			a, b := verts[i], verts[(i+1)%len(verts)]
+			if (a.y <= scanline) == (b.y <= scanline) {
+				continue
+			}
+			frac := (scanline - a.y) / (b.y - a.y)
+			xs = append(xs, a.x+frac*(b.x-a.x))
+		}
+		sort.Float64s(xs)
+		// Spans are rounded outward to whole pixels; clamp each one to start
+		// no earlier than the previous span's end so the row stays disjoint.
+		prevX1 := math.MinInt
+		for i := 0; i+1 < len(xs); i += 2 {
+			x0 := int(math.Floor(xs[i]))
+			x1 := int(math.Ceil(xs[i+1]))
+			if x0 < prevX1 {
+				x0 = prevX1
+			}
+			if x1 > x0 {
+				rects = append(rects, image.Rect(x0, y, x1, y+1))
+				prevX1 = x1
+			}
+		}
+	}
+	dx, dy, err := t.locationOffset()
+	if err != nil {
+		return nil, err
+	}
+	pt := image.Pt(int(dx), int(dy))
+	for i, rect := range rects {
+		rects[i] = rect.Add(pt)
+	}
+	return t.tr.newRectSet(t.Pos, rects), nil
+}
+
 func (t *ObjectNode) String() string {
 	return fmt.Sprintf("%v %v @ %v", t.Ident, t.Params, t.LocationParams)
 }
@@ -394,3 +777,92 @@ func (t *ObjectNode) Copy() Node {
 	}
 	return &ObjectNode{tr: t.tr, NodeType: NodeObject, Pos: t.Pos, Ident: t.Ident, Params: params_copy, LocationParams: loc_params_copy}
 }
+
+// IdentifierNode holds a reference to a named binding, e.g. the `foo` in
+// `bar = foo || rect(20,20,30,30);`.
+type IdentifierNode struct {
+	NodeType
+	Pos
+	tr    *Tree
+	Ident string
+}
+
+func (t *Tree) newIdentifier(pos Pos, ident string) *IdentifierNode {
+	return &IdentifierNode{tr: t, NodeType: NodeIdentifier, Pos: pos, Ident: ident}
+}
+
+// Reduce resolves the identifier against the Tree's scope: already-reduced
+// bindings are returned from cache, unreduced ones are reduced on demand
+// and cached, and a binding currently being reduced higher up the call
+// stack is reported as a cyclic definition.
+func (n *IdentifierNode) Reduce() (Node, error) {
+	sc := n.tr.scope
+	if sc == nil {
+		return nil, fmt.Errorf("identifier %q referenced outside of any binding scope", n.Ident)
+	}
+	if v, ok := sc.reduced[n.Ident]; ok {
+		return v, nil
+	}
+	for _, pending := range sc.stack {
+		if pending == n.Ident {
+			path := append(append([]string{}, sc.stack...), n.Ident)
+			return nil, fmt.Errorf("cyclic definition: %s", strings.Join(path, " -> "))
+		}
+	}
+	target, ok := sc.trees[n.Ident]
+	if !ok {
+		return nil, fmt.Errorf("undefined identifier %q", n.Ident)
+	}
+	sc.stack = append(sc.stack, n.Ident)
+	reduced, err := target.Root.Reduce()
+	sc.stack = sc.stack[:len(sc.stack)-1]
+	if err != nil {
+		return nil, err
+	}
+	sc.reduced[n.Ident] = reduced
+	return reduced, nil
+}
+
+func (n *IdentifierNode) String() string {
+	return n.Ident
+}
+
+func (n *IdentifierNode) tree() *Tree {
+	return n.tr
+}
+
+func (n *IdentifierNode) Copy() Node {
+	return &IdentifierNode{tr: n.tr, NodeType: NodeIdentifier, Pos: n.Pos, Ident: n.Ident}
+}
+
+// AssignNode holds a top-level named-binding assignment, e.g.
+// `foo = rect(0,0,10,10) @ (5,5);`. It is only ever produced as a
+// top-level statement; parse.Parse unpacks it into a *Tree of its own,
+// keyed by Ident, rather than leaving it in any Root.
+type AssignNode struct {
+	NodeType
+	Pos
+	tr    *Tree
+	Ident string
+	Value Node
+}
+
+func (t *Tree) newAssign(pos Pos, ident string, value Node) *AssignNode {
+	return &AssignNode{tr: t, NodeType: NodeAssign, Pos: pos, Ident: ident, Value: value}
+}
+
+func (n *AssignNode) Reduce() (Node, error) {
+	return n.Value.Reduce()
+}
+
+func (n *AssignNode) String() string {
+	return fmt.Sprintf("%s = %v", n.Ident, n.Value)
+}
+
+func (n *AssignNode) tree() *Tree {
+	return n.tr
+}
+
+func (n *AssignNode) Copy() Node {
+	return &AssignNode{tr: n.tr, NodeType: NodeAssign, Pos: n.Pos, Ident: n.Ident, Value: n.Value.Copy()}
+}
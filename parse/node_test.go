@@ -0,0 +1,184 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"image"
+	"testing"
+)
+
+func area(rects []image.Rectangle) int {
+	sum := 0
+	for _, r := range rects {
+		sum += r.Dx() * r.Dy()
+	}
+	return sum
+}
+
+func assertDisjoint(t *testing.T, rects []image.Rectangle) {
+	t.Helper()
+	for i := range rects {
+		for j := i + 1; j < len(rects); j++ {
+			if !rects[i].Intersect(rects[j]).Empty() {
+				t.Fatalf("rects overlap: %v and %v", rects[i], rects[j])
+			}
+		}
+	}
+}
+
+func reduceRectSet(t *testing.T, n Node) *RectSetNode {
+	t.Helper()
+	red, err := n.Reduce()
+	if err != nil {
+		t.Fatalf("Reduce: %v", err)
+	}
+	rs, ok := red.(*RectSetNode)
+	if !ok {
+		t.Fatalf("Reduce returned %T, want *RectSetNode", red)
+	}
+	return rs
+}
+
+func TestUnionOverlapping(t *testing.T) {
+	tr := new(Tree)
+	a := tr.newRectSet(0, []image.Rectangle{image.Rect(0, 0, 10, 10)})
+	b := tr.newRectSet(0, []image.Rectangle{image.Rect(5, 5, 15, 15)})
+	rs := reduceRectSet(t, tr.newUnion(0, a, b))
+	assertDisjoint(t, rs.Rects)
+	if got, want := area(rs.Rects), 175; got != want {
+		t.Errorf("union area = %d, want %d", got, want)
+	}
+}
+
+func TestUnionDisjoint(t *testing.T) {
+	tr := new(Tree)
+	a := tr.newRectSet(0, []image.Rectangle{image.Rect(0, 0, 10, 10)})
+	b := tr.newRectSet(0, []image.Rectangle{image.Rect(20, 20, 30, 30)})
+	rs := reduceRectSet(t, tr.newUnion(0, a, b))
+	assertDisjoint(t, rs.Rects)
+	if len(rs.Rects) != 2 {
+		t.Fatalf("len(rs.Rects) = %d, want 2", len(rs.Rects))
+	}
+	if got, want := area(rs.Rects), 200; got != want {
+		t.Errorf("union area = %d, want %d", got, want)
+	}
+}
+
+func TestIntersection(t *testing.T) {
+	tr := new(Tree)
+	a := tr.newRectSet(0, []image.Rectangle{image.Rect(0, 0, 10, 10)})
+	b := tr.newRectSet(0, []image.Rectangle{image.Rect(5, 5, 15, 15)})
+	rs := reduceRectSet(t, tr.newIntersection(0, a, b))
+	if got, want := area(rs.Rects), 25; got != want {
+		t.Errorf("intersection area = %d, want %d", got, want)
+	}
+
+	c := tr.newRectSet(0, []image.Rectangle{image.Rect(20, 20, 30, 30)})
+	empty := reduceRectSet(t, tr.newIntersection(0, a, c))
+	if len(empty.Rects) != 0 {
+		t.Errorf("disjoint intersection = %v, want empty", empty.Rects)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tr := new(Tree)
+	a := tr.newRectSet(0, []image.Rectangle{image.Rect(0, 0, 10, 10)})
+	b := tr.newRectSet(0, []image.Rectangle{image.Rect(5, 5, 15, 15)})
+	rs := reduceRectSet(t, tr.newDiff(0, a, b))
+	assertDisjoint(t, rs.Rects)
+	if got, want := area(rs.Rects), 75; got != want {
+		t.Errorf("diff area = %d, want %d", got, want)
+	}
+}
+
+// TestNestedSetOps exercises (a - b) || c, checking that reduced RectSetNodes
+// compose through another layer of set operators.
+func TestNestedSetOps(t *testing.T) {
+	tr := new(Tree)
+	a := tr.newRectSet(0, []image.Rectangle{image.Rect(0, 0, 10, 10)})
+	b := tr.newRectSet(0, []image.Rectangle{image.Rect(5, 5, 15, 15)})
+	c := tr.newRectSet(0, []image.Rectangle{image.Rect(20, 20, 30, 30)})
+	diff := tr.newDiff(0, a, b)
+	rs := reduceRectSet(t, tr.newUnion(0, diff, c))
+	assertDisjoint(t, rs.Rects)
+	if got, want := area(rs.Rects), 75+100; got != want {
+		t.Errorf("nested area = %d, want %d", got, want)
+	}
+}
+
+func TestObjectNodeRect(t *testing.T) {
+	tr := new(Tree)
+	params := []Node{
+		mustNumber(t, tr, "0"),
+		mustNumber(t, tr, "0"),
+		mustNumber(t, tr, "10"),
+		mustNumber(t, tr, "10"),
+	}
+	loc := []Node{mustNumber(t, tr, "5"), mustNumber(t, tr, "5")}
+	obj := tr.newObject(0, "rect", params, loc)
+	rs := reduceRectSet(t, obj)
+	want := image.Rect(5, 5, 15, 15)
+	if len(rs.Rects) != 1 || rs.Rects[0] != want {
+		t.Errorf("rect @ (5,5) = %v, want [%v]", rs.Rects, want)
+	}
+}
+
+func mustNumber(t *testing.T, tr *Tree, text string) *NumberNode {
+	t.Helper()
+	n, err := tr.newNumber(0, text, itemNumber)
+	if err != nil {
+		t.Fatalf("newNumber(%q): %v", text, err)
+	}
+	return n
+}
+
+func TestObjectNodeCircle(t *testing.T) {
+	tr := new(Tree)
+	params := []Node{
+		mustNumber(t, tr, "0"),
+		mustNumber(t, tr, "0"),
+		mustNumber(t, tr, "10"),
+	}
+	obj := tr.newObject(0, "circle", params, nil)
+	rs := reduceRectSet(t, obj)
+	assertDisjoint(t, rs.Rects)
+	for _, r := range rs.Rects {
+		if r.Min.X < -10 || r.Max.X > 11 || r.Min.Y < -10 || r.Max.Y > 11 {
+			t.Errorf("circle row %v falls outside radius 10 bounding box", r)
+		}
+	}
+	// A filled disc of radius 10 has area pi*r^2 ~= 314; the scanline
+	// decomposition should land close to that.
+	if got := area(rs.Rects); got < 300 || got > 330 {
+		t.Errorf("circle area = %d, want ~314", got)
+	}
+}
+
+func TestObjectNodePoly(t *testing.T) {
+	tr := new(Tree)
+	str := tr.newString(0, `"0 0 10 0 10 10 0 10"`, "0 0 10 0 10 10 0 10")
+	obj := tr.newObject(0, "poly", []Node{str}, nil)
+	rs := reduceRectSet(t, obj)
+	assertDisjoint(t, rs.Rects)
+	if got, want := area(rs.Rects), 100; got != want {
+		t.Errorf("square poly area = %d, want %d", got, want)
+	}
+}
+
+func TestObjectNodePolyLocation(t *testing.T) {
+	tr := new(Tree)
+	str := tr.newString(0, `"0 0 10 0 10 10 0 10"`, "0 0 10 0 10 10 0 10")
+	loc := []Node{mustNumber(t, tr, "5"), mustNumber(t, tr, "5")}
+	obj := tr.newObject(0, "poly", []Node{str}, loc)
+	rs := reduceRectSet(t, obj)
+	if got, want := area(rs.Rects), 100; got != want {
+		t.Errorf("translated poly area = %d, want %d", got, want)
+	}
+	for _, r := range rs.Rects {
+		if r.Min.X < 5 || r.Min.Y < 5 {
+			t.Errorf("translated poly row %v falls before the @ (5,5) offset", r)
+		}
+	}
+}
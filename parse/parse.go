@@ -0,0 +1,421 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package parse builds parse trees for sml scenes.
+package parse
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+)
+
+// Tree is the representation of a single parsed scene, or of a single
+// named binding pulled out of one. Parsing one source text with one or
+// more top-level `name = expr;` bindings produces one *Tree per binding,
+// all sharing the same scope so identifiers can refer to one another.
+type Tree struct {
+	Name string    // name of this tree: the binding identifier, or the file name for a bare top-level expression.
+	Root *ListNode // top-level root of the tree.
+
+	// Parsing state; stopParse clears only lex. scope is kept after parsing,
+	// since IdentifierNode.Reduce resolves names through it.
+	text      string // text parsed to create the tree.
+	lex       *lexer
+	token     [3]item // three-token lookahead for parser.
+	peekCount int
+	scope     *scope // symbol table shared by every Tree produced from one Parse call.
+}
+
+// scope is the symbol table shared across every Tree produced by a single
+// call to Parse, so that `bar = foo || rect(...)` can resolve `foo`.
+type scope struct {
+	trees   map[string]*Tree // raw (unreduced) tree per binding name.
+	reduced map[string]Node  // cache of already-reduced bindings.
+	stack   []string         // bindings currently being reduced, for cycle detection.
+	file    *File            // resolves Pos to Position for this Parse call's source text.
+	errors  ErrorList        // every parse error found so far, across all statements.
+}
+
+func newScope() *scope {
+	return &scope{trees: make(map[string]*Tree), reduced: make(map[string]Node)}
+}
+
+func (s *scope) newTree(name string) (*Tree, error) {
+	if _, dup := s.trees[name]; dup {
+		return nil, fmt.Errorf("binding %q already defined", name)
+	}
+	bt := &Tree{Name: name, scope: s}
+	s.trees[name] = bt
+	return bt, nil
+}
+
+// New allocates a new, unparsed tree with the given name.
+func New(name string) *Tree {
+	return &Tree{Name: name}
+}
+
+// Parse parses text as a scene, returning one *Tree per top-level
+// `name = expr;` binding plus, if text holds one bare expression with no
+// binding (at any statement position), one more Tree keyed by name. If text contains malformed
+// statements, Parse still returns every binding it successfully
+// recovered alongside an ErrorList describing what went wrong.
+func Parse(name, text string) (map[string]*Tree, error) {
+	t := New(name)
+	t.scope = newScope()
+	err := t.Parse(text)
+	treemap := make(map[string]*Tree, len(t.scope.trees)+1)
+	for k, v := range t.scope.trees {
+		treemap[k] = v
+	}
+	if t.Root != nil {
+		treemap[name] = t
+	}
+	return treemap, err
+}
+
+// Parse parses the text, settings t.Root (and any sibling bindings in
+// t.scope) or returning an error. If the text contains more than one
+// malformed statement, Parse keeps going after each one (resynchronizing
+// at the next top-level ';') and returns every error found as an
+// ErrorList, rather than aborting at the first bad token.
+func (t *Tree) Parse(text string) (err error) {
+	defer t.recover(&err)
+	if t.scope == nil {
+		t.scope = newScope()
+	}
+	t.scope.file = NewFile(t.Name, len(text))
+	t.text = text
+	t.lex = lex(t.Name, text, t.scope.file)
+	t.peekCount = 0
+	t.parseTopLevel()
+	t.stopParse()
+	if len(t.scope.errors) > 0 {
+		return t.scope.errors
+	}
+	return nil
+}
+
+// stopParse releases parsing state not needed after parsing is done.
+func (t *Tree) stopParse() {
+	t.lex = nil
+}
+
+// parseTopLevel parses a ';'-separated sequence of bindings and/or a
+// single bare expression, recovering from a malformed statement by
+// resynchronizing at the next ';' so later statements are still parsed
+// and reported instead of the whole Parse aborting on the first error.
+func (t *Tree) parseTopLevel() {
+	for {
+		if t.peekNonSpace().typ == itemEOF {
+			return
+		}
+		if t.parseOneStatement() {
+			return
+		}
+	}
+}
+
+// errSync is panicked by errorf to unwind out of the current top-level
+// statement; it is caught by parseOneStatement, never by Tree.recover.
+type errSync struct{}
+
+// parseOneStatement parses and installs a single top-level statement and
+// checks that it's properly followed by ';' or EOF. A parse error
+// anywhere in that work is recorded in t.scope.errors and the lexer is
+// advanced to the next top-level ';' (or EOF), so parseTopLevel can keep
+// going instead of the whole Parse aborting on the first bad token.
+// It reports whether the caller has reached EOF and should stop.
+func (t *Tree) parseOneStatement() (atEOF bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(errSync); !ok {
+				panic(e)
+			}
+			atEOF = t.sync()
+		}
+	}()
+	stmt := t.statement()
+	if assign, ok := stmt.(*AssignNode); ok {
+		bt, err := t.scope.newTree(assign.Ident)
+		if err != nil {
+			t.error(assign.Pos, err)
+		}
+		list := bt.newList(assign.Pos)
+		list.append(assign.Value)
+		bt.Root = list
+	} else {
+		if t.Root != nil {
+			t.errorf(stmt.Position(), "only one bare expression is allowed per file; give it a name instead")
+		}
+		list := t.newList(stmt.Position())
+		list.append(stmt)
+		t.Root = list
+	}
+	switch tok := t.peekNonSpace(); tok.typ {
+	case itemSemicolon:
+		t.nextNonSpace()
+		return false
+	case itemEOF:
+		return true
+	default:
+		t.unexpected(tok, "top-level statement")
+		return false
+	}
+}
+
+// sync discards tokens until the next top-level ';' (which it also
+// consumes) or EOF, tracking paren depth so a ';' nested inside an
+// unbalanced argument list isn't mistaken for a synchronization point. It
+// reports whether it stopped at EOF.
+func (t *Tree) sync() (atEOF bool) {
+	depth := 0
+	for {
+		tok := t.next()
+		switch tok.typ {
+		case itemLeftParen:
+			depth++
+		case itemRightParen:
+			depth--
+		case itemSemicolon:
+			if depth <= 0 {
+				return false
+			}
+		case itemEOF:
+			t.backup()
+			return true
+		}
+	}
+}
+
+// statement parses either `ident = expr` or a bare expr.
+func (t *Tree) statement() Node {
+	if tok := t.peekNonSpace(); tok.typ == itemIdentifier {
+		t.nextNonSpace()
+		if t.peekNonSpace().typ == itemAssign {
+			t.nextNonSpace()
+			value := t.expression()
+			return t.newAssign(tok.pos, tok.val, value)
+		}
+		t.backup2(tok)
+	}
+	return t.expression()
+}
+
+// expression parses a left-associative chain of '-', '&&', and '||'
+// operators over terms.
+func (t *Tree) expression() Node {
+	left := t.term()
+	for {
+		switch tok := t.peekNonSpace(); tok.typ {
+		case itemDiff:
+			t.nextNonSpace()
+			left = t.newDiff(tok.pos, left, t.term())
+		case itemIntersection:
+			t.nextNonSpace()
+			left = t.newIntersection(tok.pos, left, t.term())
+		case itemUnion:
+			t.nextNonSpace()
+			left = t.newUnion(tok.pos, left, t.term())
+		default:
+			return left
+		}
+	}
+}
+
+// term parses a single operand of an expression: a parenthesized
+// sub-expression, a number, a string, an object call, or an identifier
+// reference.
+func (t *Tree) term() Node {
+	tok := t.nextNonSpace()
+	switch {
+	case tok.typ == itemLeftParen:
+		e := t.expression()
+		t.expect(itemRightParen, "parenthesized expression")
+		return e
+	case tok.typ == itemNumber || tok.typ == itemComplex:
+		n, err := t.newNumber(tok.pos, tok.val, tok.typ)
+		if err != nil {
+			t.error(tok.pos, err)
+		}
+		return n
+	case tok.typ == itemString:
+		return t.string(tok)
+	case tok.typ > itemKeyword:
+		return t.objectCall(tok)
+	case tok.typ == itemIdentifier:
+		if t.peekNonSpace().typ == itemLeftParen {
+			return t.objectCall(tok)
+		}
+		return t.newIdentifier(tok.pos, tok.val)
+	}
+	t.unexpected(tok, "expression")
+	return nil
+}
+
+// objectCall parses the `(params...) @ (locationParams...)` tail of an
+// object declaration whose head (keyword or identifier) is tok.
+func (t *Tree) objectCall(tok item) Node {
+	t.expect(itemLeftParen, "object arguments")
+	params := t.argumentList()
+	t.expect(itemRightParen, "object arguments")
+	var locationParams []Node
+	if t.peekNonSpace().typ == itemLocation {
+		t.nextNonSpace()
+		t.expect(itemLeftParen, "location arguments")
+		locationParams = t.argumentList()
+		t.expect(itemRightParen, "location arguments")
+	}
+	return t.newObject(tok.pos, tok.val, params, locationParams)
+}
+
+// argumentList parses a comma-separated list of number/string arguments,
+// stopping (without consuming) at the first token that isn't a comma.
+func (t *Tree) argumentList() []Node {
+	var args []Node
+	if t.peekNonSpace().typ == itemRightParen {
+		return args
+	}
+	for {
+		args = append(args, t.argument())
+		if t.peekNonSpace().typ != itemComma {
+			return args
+		}
+		t.nextNonSpace()
+	}
+}
+
+// argument parses a single object or location parameter.
+func (t *Tree) argument() Node {
+	tok := t.nextNonSpace()
+	switch tok.typ {
+	case itemNumber, itemComplex:
+		n, err := t.newNumber(tok.pos, tok.val, tok.typ)
+		if err != nil {
+			t.error(tok.pos, err)
+		}
+		return n
+	case itemString:
+		return t.string(tok)
+	}
+	t.unexpected(tok, "argument")
+	return nil
+}
+
+// string turns an itemString token (which spans the surrounding quotes)
+// into a StringNode, unescaping it the same way a Go string literal would.
+func (t *Tree) string(tok item) Node {
+	text, err := strconv.Unquote(tok.val)
+	if err != nil {
+		t.error(tok.pos, err)
+	}
+	return t.newString(tok.pos, tok.val, text)
+}
+
+// Parsing support methods, in the style of text/template/parse.
+
+// next returns the next token.
+func (t *Tree) next() item {
+	if t.peekCount > 0 {
+		t.peekCount--
+	} else {
+		t.token[0] = t.lex.nextItem()
+	}
+	return t.token[t.peekCount]
+}
+
+// backup backs the input stream up one token.
+func (t *Tree) backup() {
+	t.peekCount++
+}
+
+// backup2 backs the input stream up two tokens, given the first token
+// already read via peekNonSpace/nextNonSpace.
+func (t *Tree) backup2(t1 item) {
+	t.token[1] = t1
+	t.peekCount = 2
+}
+
+// peek returns but does not consume the next token.
+func (t *Tree) peek() item {
+	if t.peekCount > 0 {
+		return t.token[t.peekCount-1]
+	}
+	t.peekCount = 1
+	t.token[0] = t.lex.nextItem()
+	return t.token[0]
+}
+
+// nextNonSpace returns the next non-space token.
+func (t *Tree) nextNonSpace() (tok item) {
+	for {
+		tok = t.next()
+		if tok.typ != itemSpace {
+			break
+		}
+	}
+	return tok
+}
+
+// peekNonSpace returns but does not consume the next non-space token.
+func (t *Tree) peekNonSpace() (tok item) {
+	tok = t.nextNonSpace()
+	t.backup()
+	return tok
+}
+
+// expect consumes the next non-space token, erroring if it isn't of the
+// expected type.
+func (t *Tree) expect(expected itemType, context string) item {
+	tok := t.nextNonSpace()
+	if tok.typ != expected {
+		t.unexpected(tok, context)
+	}
+	return tok
+}
+
+// errorf records a structured Error at pos, resolved to a file/line/column
+// Position via t.scope.file, then panics errSync{} to unwind to the
+// nearest parseOneStatement, which resynchronizes and keeps going.
+func (t *Tree) errorf(pos Pos, format string, args ...interface{}) {
+	position := Position{Line: 1, Column: int(pos) + 1}
+	if t.scope != nil && t.scope.file != nil {
+		position = t.scope.file.Position(int(pos))
+	}
+	msg := fmt.Sprintf(format, args...)
+	if t.scope != nil {
+		t.scope.errors = append(t.scope.errors, &Error{Position: position, Msg: msg})
+	}
+	panic(errSync{})
+}
+
+// error is errorf with a pre-formatted error.
+func (t *Tree) error(pos Pos, err error) {
+	t.errorf(pos, "%s", err)
+}
+
+// unexpected complains about the token and aborts the current statement.
+func (t *Tree) unexpected(tok item, context string) {
+	t.errorf(tok.pos, "unexpected %s in %s", tok, context)
+}
+
+// recover is the final safety net around a whole Parse call: it turns a
+// panic that escaped parseOneStatement's own recovery (anything other than
+// an errSync) into a returned error. A runtime.Error is re-panicked.
+func (t *Tree) recover(errp *error) {
+	e := recover()
+	if e != nil {
+		if _, ok := e.(runtime.Error); ok {
+			panic(e)
+		}
+		if t != nil {
+			t.stopParse()
+		}
+		if err, ok := e.(error); ok {
+			*errp = err
+		} else {
+			*errp = fmt.Errorf("%v", e)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestParseBareExpression(t *testing.T) {
+	trees, err := Parse("hello", "rect(0,0,10,10)")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	tr, ok := trees["hello"]
+	if !ok {
+		t.Fatalf("trees = %v, want entry for %q", trees, "hello")
+	}
+	rs := reduceRectSet(t, tr.Root)
+	want := image.Rect(0, 0, 10, 10)
+	if len(rs.Rects) != 1 || rs.Rects[0] != want {
+		t.Errorf("Root.Reduce() = %v, want [%v]", rs.Rects, want)
+	}
+}
+
+func TestParseNamedBindings(t *testing.T) {
+	src := `foo = rect(0,0,10,10) @ (5,5); bar = foo || rect(20,20,30,30);`
+	trees, err := Parse("scene", src)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if _, ok := trees["foo"]; !ok {
+		t.Fatalf("trees = %v, want entry for %q", trees, "foo")
+	}
+	bar, ok := trees["bar"]
+	if !ok {
+		t.Fatalf("trees = %v, want entry for %q", trees, "bar")
+	}
+	rs := reduceRectSet(t, bar.Root)
+	if got, want := area(rs.Rects), 100+100; got != want {
+		t.Errorf("bar area = %d, want %d", got, want)
+	}
+}
+
+func TestParseCyclicDefinition(t *testing.T) {
+	src := `foo = bar; bar = foo;`
+	trees, err := Parse("scene", src)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	_, err = trees["foo"].Root.Reduce()
+	if err == nil {
+		t.Fatal("Reduce() = nil error, want cyclic definition error")
+	}
+	if !strings.Contains(err.Error(), "cyclic definition:") {
+		t.Errorf("err = %v, want it to mention a cyclic definition", err)
+	}
+}
+
+func TestParseUndefinedIdentifier(t *testing.T) {
+	trees, err := Parse("scene", "foo = bar;")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	_, err = trees["foo"].Root.Reduce()
+	if err == nil {
+		t.Fatal("Reduce() = nil error, want undefined identifier error")
+	}
+}
+
+func TestParseMultiErrorRecovery(t *testing.T) {
+	// "foo 5" is a malformed statement (a stray 5 where ';' or EOF was
+	// expected); Parse should record that error, resynchronize at the
+	// following ';', and still successfully parse "bar".
+	src := `foo 5; bar = rect(0,0,10,10);`
+	trees, err := Parse("scene", src)
+	if err == nil {
+		t.Fatal("Parse() = nil error, want one reporting the malformed statement")
+	}
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("err = %T, want ErrorList", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("len(errors) = %d, want 1: %v", len(list), list)
+	}
+	if trees == nil {
+		t.Fatal("trees = nil, want the successfully parsed bindings despite the earlier error")
+	}
+	bar, ok := trees["bar"]
+	if !ok {
+		t.Fatalf("trees = %v, want entry for %q", trees, "bar")
+	}
+	rs := reduceRectSet(t, bar.Root)
+	if len(rs.Rects) != 1 {
+		t.Errorf("bar.Root.Reduce() = %v, want a single rect", rs.Rects)
+	}
+}
+
+func TestParseRecoversFromLexError(t *testing.T) {
+	// '#' is an unrecognized character; Parse should record the lex error
+	// but keep tokenizing the rest of the input instead of treating the
+	// lexer going silent as having reached EOF, so "bar" still parses.
+	src := `foo = #; bar = rect(0,0,10,10);`
+	trees, err := Parse("scene", src)
+	if err == nil {
+		t.Fatal("Parse() = nil error, want one reporting the bad character")
+	}
+	if _, ok := err.(ErrorList); !ok {
+		t.Fatalf("err = %T, want ErrorList", err)
+	}
+	bar, ok := trees["bar"]
+	if !ok {
+		t.Fatalf("trees = %v, want entry for %q despite the earlier lex error", trees, "bar")
+	}
+	rs := reduceRectSet(t, bar.Root)
+	if len(rs.Rects) != 1 {
+		t.Errorf("bar.Root.Reduce() = %v, want a single rect", rs.Rects)
+	}
+}
+
+func TestParseCircle(t *testing.T) {
+	trees, err := Parse("hello", "circle(0,0,10)")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	rs := reduceRectSet(t, trees["hello"].Root)
+	if got := area(rs.Rects); got < 300 || got > 330 {
+		t.Errorf("circle area = %d, want ~314", got)
+	}
+}
+
+func TestParsePoly(t *testing.T) {
+	trees, err := Parse("hello", `poly("0 0 10 0 10 10 0 10")`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	rs := reduceRectSet(t, trees["hello"].Root)
+	if got, want := area(rs.Rects), 100; got != want {
+		t.Errorf("poly area = %d, want %d", got, want)
+	}
+}
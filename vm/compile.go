@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"fmt"
+	"image"
+
+	"sml/parse"
+)
+
+// Compile lowers a parsed, unreduced scene into a Program. A tree already
+// reduced to a *parse.RectSetNode is not accepted.
+// Identifiers that refer to other top-level bindings in the same scene are
+// left as unresolved OpPushRef instructions: the caller Runs each binding's
+// Program in dependency order and threads the results through via env.
+func Compile(t *parse.Tree) (*Program, error) {
+	c := &compiler{names: make(map[string]int)}
+	if err := c.compileNode(t.Root); err != nil {
+		return nil, err
+	}
+	c.emit(Instruction{Op: OpReturn})
+	return &Program{code: c.code, names: c.namesList}, nil
+}
+
+type compiler struct {
+	code      []Instruction
+	names     map[string]int
+	namesList []string
+}
+
+func (c *compiler) emit(in Instruction) {
+	c.code = append(c.code, in)
+}
+
+func (c *compiler) nameIndex(name string) int {
+	if i, ok := c.names[name]; ok {
+		return i
+	}
+	i := len(c.namesList)
+	c.names[name] = i
+	c.namesList = append(c.namesList, name)
+	return i
+}
+
+func (c *compiler) compileNode(n parse.Node) error {
+	switch v := n.(type) {
+	case *parse.ListNode:
+		for _, elem := range v.Nodes {
+			if err := c.compileNode(elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *parse.ObjectNode:
+		return c.compileObject(v)
+	case *parse.DiffNode:
+		if err := c.compileNode(v.Lefthand); err != nil {
+			return err
+		}
+		if err := c.compileNode(v.Righthand); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpDiff})
+		return nil
+	case *parse.IntersectionNode:
+		if err := c.compileNode(v.Lefthand); err != nil {
+			return err
+		}
+		if err := c.compileNode(v.Righthand); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpIntersect})
+		return nil
+	case *parse.UnionNode:
+		if err := c.compileNode(v.Lefthand); err != nil {
+			return err
+		}
+		if err := c.compileNode(v.Righthand); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpUnion})
+		return nil
+	case *parse.IdentifierNode:
+		// t.Root is never an *parse.AssignNode: parseOneStatement always
+		// unwraps a binding's value into a fresh sibling Tree before
+		// Compile sees it, and expression()/term() never parse one
+		// nested inside another expression. So every identifier Compile
+		// encounters refers to another top-level binding, resolved at
+		// Run time via OpPushRef/env, never a local set by OpStore.
+		c.emit(Instruction{Op: OpPushRef, Arg: c.nameIndex(v.Ident)})
+		return nil
+	}
+	return fmt.Errorf("vm: cannot compile %T", n)
+}
+
+func (c *compiler) compileObject(o *parse.ObjectNode) error {
+	if o.Ident != "rect" {
+		return fmt.Errorf("vm: unsupported object %q", o.Ident)
+	}
+	if len(o.Params) != 4 {
+		return fmt.Errorf("vm: rect wants 4 params (x0,y0,x1,y1), got %d", len(o.Params))
+	}
+	coords := make([]int, len(o.Params))
+	for i, p := range o.Params {
+		v, err := numberAsInt(p)
+		if err != nil {
+			return fmt.Errorf("vm: rect param %d: %v", i, err)
+		}
+		coords[i] = v
+	}
+	c.emit(Instruction{Op: OpPushRect, Rect: image.Rect(coords[0], coords[1], coords[2], coords[3]).Canon()})
+	if len(o.LocationParams) == 0 {
+		return nil
+	}
+	if len(o.LocationParams) != 2 {
+		return fmt.Errorf("vm: @ location wants 2 params (dx,dy), got %d", len(o.LocationParams))
+	}
+	dx, err := numberAsInt(o.LocationParams[0])
+	if err != nil {
+		return fmt.Errorf("vm: @ location param 0: %v", err)
+	}
+	dy, err := numberAsInt(o.LocationParams[1])
+	if err != nil {
+		return fmt.Errorf("vm: @ location param 1: %v", err)
+	}
+	c.emit(Instruction{Op: OpTranslate, Dx: dx, Dy: dy})
+	return nil
+}
+
+// numberAsInt coerces a *parse.NumberNode param to an int, erroring if the
+// value isn't representable as one.
+func numberAsInt(n parse.Node) (int, error) {
+	num, ok := n.(*parse.NumberNode)
+	if !ok {
+		return 0, fmt.Errorf("not a number: %v", n)
+	}
+	if num.IsInt {
+		return int(num.Int64), nil
+	}
+	if num.IsFloat && float64(int64(num.Float64)) == num.Float64 {
+		return int(num.Float64), nil
+	}
+	return 0, fmt.Errorf("value %q is not representable as an integer", num.Text)
+}
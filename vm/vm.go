@@ -0,0 +1,167 @@
+// Package vm compiles an unreduced sml parse tree into a small bytecode
+// program that can be run many times against different environments
+// without re-walking the AST.
+package vm
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"sml/parse"
+)
+
+// Op identifies a single VM instruction.
+type Op int
+
+const (
+	OpPushRect Op = iota // push a literal rectangle set: [Rect]
+	OpPushRef            // push the RectSet bound to names[Arg] in the caller's env
+	OpTranslate          // translate the top of stack by (Dx, Dy) in place
+	OpUnion              // pop b, a; push a || b
+	OpIntersect          // pop b, a; push a && b
+	OpDiff               // pop b, a; push a - b
+	OpStore              // pop and remember the top of stack as names[Arg]; not emitted by Compile (see compileNode), only usable via hand-built Instructions
+	OpLoad               // push the value most recently OpStore'd as names[Arg]; not emitted by Compile (see compileNode), only usable via hand-built Instructions
+	OpReturn             // stop, returning the top of stack
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpPushRect:
+		return "PushRect"
+	case OpPushRef:
+		return "PushRef"
+	case OpTranslate:
+		return "Translate"
+	case OpUnion:
+		return "Union"
+	case OpIntersect:
+		return "Intersect"
+	case OpDiff:
+		return "Diff"
+	case OpStore:
+		return "Store"
+	case OpLoad:
+		return "Load"
+	case OpReturn:
+		return "Return"
+	}
+	return fmt.Sprintf("Op(%d)", int(o))
+}
+
+// Instruction is a single VM op. Only the fields relevant to Op are
+// meaningful; the rest are zero.
+type Instruction struct {
+	Op   Op
+	Rect image.Rectangle // OpPushRect
+	Dx   int             // OpTranslate
+	Dy   int             // OpTranslate
+	Arg  int             // OpPushRef, OpStore, OpLoad: index into Program.names
+}
+
+func (in Instruction) disassemble(names []string) string {
+	switch in.Op {
+	case OpPushRect:
+		return fmt.Sprintf("%s %d %d %d %d", in.Op, in.Rect.Min.X, in.Rect.Min.Y, in.Rect.Max.X, in.Rect.Max.Y)
+	case OpPushRef, OpStore, OpLoad:
+		return fmt.Sprintf("%s %s", in.Op, names[in.Arg])
+	case OpTranslate:
+		return fmt.Sprintf("%s %d %d", in.Op, in.Dx, in.Dy)
+	default:
+		return in.Op.String()
+	}
+}
+
+// RectSet is the VM's one value type: a canonical (sorted, disjoint,
+// non-empty) set of axis-aligned rectangles.
+type RectSet []image.Rectangle
+
+// Program is a compiled, linear instruction stream ready to Run.
+type Program struct {
+	code  []Instruction
+	names []string // constant pool referenced by OpPushRef/OpStore/OpLoad
+}
+
+// Disassemble renders the program as human-readable text, one instruction
+// per line, for debugging.
+func (p *Program) Disassemble() string {
+	var b strings.Builder
+	for i, in := range p.code {
+		fmt.Fprintf(&b, "%4d %s\n", i, in.disassemble(p.names))
+	}
+	return b.String()
+}
+
+// Run executes the program against env, which supplies the RectSet value
+// of every name the program reads via OpPushRef (typically the already-Run
+// result of a sibling binding's Program). Run is allocation-light: a single
+// preallocated stack and no re-walking of any tree.
+func (p *Program) Run(env map[string]any) (RectSet, error) {
+	stack := make([]RectSet, 0, 8)
+	locals := make(map[string]RectSet, len(p.names))
+	for _, in := range p.code {
+		switch in.Op {
+		case OpPushRect:
+			stack = append(stack, parse.CanonicalRects([]image.Rectangle{in.Rect}))
+		case OpPushRef:
+			name := p.names[in.Arg]
+			v, ok := env[name]
+			if !ok {
+				return nil, fmt.Errorf("vm: undefined reference %q", name)
+			}
+			rs, ok := v.(RectSet)
+			if !ok {
+				return nil, fmt.Errorf("vm: env[%q] is %T, want vm.RectSet", name, v)
+			}
+			stack = append(stack, rs)
+		case OpTranslate:
+			top := stack[len(stack)-1]
+			stack[len(stack)-1] = translate(top, in.Dx, in.Dy)
+		case OpUnion, OpIntersect, OpDiff:
+			b := stack[len(stack)-1]
+			a := stack[len(stack)-2]
+			stack = stack[:len(stack)-2]
+			var merged RectSet
+			switch in.Op {
+			case OpUnion:
+				merged = parse.UnionRectSets(a, b)
+			case OpIntersect:
+				merged = parse.IntersectRectSets(a, b)
+			case OpDiff:
+				merged = parse.DiffRectSets(a, b)
+			}
+			stack = append(stack, merged)
+		case OpStore:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("vm: Store %s with empty stack", p.names[in.Arg])
+			}
+			locals[p.names[in.Arg]] = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case OpLoad:
+			name := p.names[in.Arg]
+			rs, ok := locals[name]
+			if !ok {
+				return nil, fmt.Errorf("vm: Load of %q before it was Stored", name)
+			}
+			stack = append(stack, rs)
+		case OpReturn:
+			if len(stack) == 0 {
+				return nil, nil
+			}
+			return stack[len(stack)-1], nil
+		default:
+			return nil, fmt.Errorf("vm: unknown op %v", in.Op)
+		}
+	}
+	return nil, fmt.Errorf("vm: program fell off the end without Return")
+}
+
+// translate returns rs shifted by (dx, dy).
+func translate(rs RectSet, dx, dy int) RectSet {
+	out := make(RectSet, len(rs))
+	for i, r := range rs {
+		out[i] = r.Add(image.Pt(dx, dy))
+	}
+	return parse.CanonicalRects(out)
+}
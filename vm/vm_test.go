@@ -0,0 +1,109 @@
+package vm
+
+import (
+	"image"
+	"testing"
+
+	"sml/parse"
+)
+
+func compileProgram(t *testing.T, src string, name string) *Program {
+	t.Helper()
+	trees, err := parse.Parse("scene", src)
+	if err != nil {
+		t.Fatalf("parse.Parse: %v", err)
+	}
+	tr, ok := trees[name]
+	if !ok {
+		t.Fatalf("trees = %v, want entry for %q", trees, name)
+	}
+	p, err := Compile(tr)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	return p
+}
+
+func TestRunRect(t *testing.T) {
+	p := compileProgram(t, "rect(0,0,10,10) @ (5,5)", "scene")
+	rs, err := p.Run(nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := image.Rect(5, 5, 15, 15)
+	if len(rs) != 1 || rs[0] != want {
+		t.Errorf("Run() = %v, want [%v]", rs, want)
+	}
+}
+
+func TestRunUnionAcrossPrograms(t *testing.T) {
+	foo := compileProgram(t, "foo = rect(0,0,10,10);", "foo")
+	bar := compileProgram(t, "foo = rect(0,0,10,10); bar = foo || rect(20,20,30,30);", "bar")
+
+	fooResult, err := foo.Run(nil)
+	if err != nil {
+		t.Fatalf("foo.Run: %v", err)
+	}
+	barResult, err := bar.Run(map[string]any{"foo": fooResult})
+	if err != nil {
+		t.Fatalf("bar.Run: %v", err)
+	}
+	wantArea := 100 + 100
+	gotArea := 0
+	for _, r := range barResult {
+		gotArea += r.Dx() * r.Dy()
+	}
+	if gotArea != wantArea {
+		t.Errorf("bar area = %d, want %d", gotArea, wantArea)
+	}
+}
+
+func TestRunUndefinedReference(t *testing.T) {
+	p := compileProgram(t, "foo = bar;", "foo")
+	if _, err := p.Run(nil); err == nil {
+		t.Fatal("Run() = nil error, want undefined reference error")
+	}
+}
+
+// TestRunStoreLoad exercises OpStore/OpLoad directly via a hand-built
+// Program: Compile never emits them (see compileNode), but they're part
+// of the VM's documented op set, so they get covered here instead.
+func TestRunStoreLoad(t *testing.T) {
+	p := &Program{
+		names: []string{"tmp"},
+		code: []Instruction{
+			{Op: OpPushRect, Rect: image.Rect(0, 0, 10, 10)},
+			{Op: OpStore, Arg: 0},
+			{Op: OpLoad, Arg: 0},
+			{Op: OpLoad, Arg: 0},
+			{Op: OpUnion},
+			{Op: OpReturn},
+		},
+	}
+	rs, err := p.Run(nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := image.Rect(0, 0, 10, 10)
+	if len(rs) != 1 || rs[0] != want {
+		t.Errorf("Run() = %v, want [%v]", rs, want)
+	}
+}
+
+func TestRunLoadBeforeStore(t *testing.T) {
+	p := &Program{
+		names: []string{"tmp"},
+		code:  []Instruction{{Op: OpLoad, Arg: 0}, {Op: OpReturn}},
+	}
+	if _, err := p.Run(nil); err == nil {
+		t.Fatal("Run() = nil error, want load-before-store error")
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	p := compileProgram(t, "rect(0,0,10,10) @ (5,5) - rect(1,1,2,2)", "scene")
+	d := p.Disassemble()
+	if d == "" {
+		t.Fatal("Disassemble() = \"\", want non-empty listing")
+	}
+}